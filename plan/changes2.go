@@ -0,0 +1,176 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// ChangeVerb identifies the kind of action a Change2 represents.
+type ChangeVerb string
+
+const (
+	// ChangeVerbCreate means the record group did not exist before and must be created.
+	ChangeVerbCreate ChangeVerb = "CREATE"
+	// ChangeVerbChange means the record group exists on both sides but its targets,
+	// TTL or provider-specific properties differ, or its TXT owner is being migrated.
+	ChangeVerbChange ChangeVerb = "CHANGE"
+	// ChangeVerbDelete means the record group is no longer desired and must be removed.
+	ChangeVerbDelete ChangeVerb = "DELETE"
+)
+
+// Change2 is the record-group view of a single planKey's diff: instead of a
+// pair of index-aligned UpdateOld/UpdateNew endpoints, it carries the
+// target-level adds/removes plus the TTL/provider-specific deltas for that
+// (dnsName, recordType, setIdentifier) group in one place.
+type Change2 struct {
+	// PlanKey identifies the record group this change applies to.
+	PlanKey planKey
+	// Verb is the action to take for this group.
+	Verb ChangeVerb
+	// Old is the current record occupying this group, nil for ChangeVerbCreate.
+	Old *endpoint.Endpoint
+	// New is the desired record for this group, nil for ChangeVerbDelete.
+	New *endpoint.Endpoint
+	// TargetsAdded lists targets present in New but not in Old.
+	TargetsAdded endpoint.Targets
+	// TargetsRemoved lists targets present in Old but not in New.
+	TargetsRemoved endpoint.Targets
+	// TTLChanged reports whether the record's TTL needs to be updated.
+	TTLChanged bool
+	// ProviderSpecificChanged reports whether a provider-specific property needs to be updated.
+	ProviderSpecificChanged bool
+}
+
+func (c *Change2) String() string {
+	return fmt.Sprintf("Change2{key=%v, verb=%s, added=%v, removed=%v, ttlChanged=%v, providerSpecificChanged=%v}",
+		c.PlanKey, c.Verb, c.TargetsAdded, c.TargetsRemoved, c.TTLChanged, c.ProviderSpecificChanged)
+}
+
+// Changes2 is the record-group diff of the actions necessary to move towards
+// the desired state: at most one Change2 per planKey (none for an unchanged
+// group), rather than the
+// per-record Create/UpdateOld/UpdateNew/Delete slices of Changes. See
+// Changes2.Lower for providers that only understand the legacy shape.
+type Changes2 struct {
+	Changes []*Change2
+}
+
+// HasChanges reports whether there is any work left to do.
+func (c *Changes2) HasChanges() bool {
+	return len(c.Changes) > 0
+}
+
+// ProviderSupportsChanges2 is implemented by providers that can consume the
+// Changes2 representation directly, such as providers with per-rrset APIs
+// (RFC2136, PowerDNS, Azure) that would otherwise receive redundant
+// full-rrset replacements under the legacy Changes. Providers that do not
+// implement this interface keep consuming the legacy Plan.Changes.
+type ProviderSupportsChanges2 interface {
+	SupportsChanges2() bool
+}
+
+// Lower converts a Changes2 into the legacy, per-record Changes shape so that
+// providers which have not opted in to Changes2 keep working unmodified.
+func (c *Changes2) Lower() *Changes {
+	legacy := &Changes{}
+	for _, change := range c.Changes {
+		switch change.Verb {
+		case ChangeVerbCreate:
+			legacy.Create = append(legacy.Create, change.New)
+		case ChangeVerbDelete:
+			legacy.Delete = append(legacy.Delete, change.Old)
+		case ChangeVerbChange:
+			legacy.UpdateOld = append(legacy.UpdateOld, change.Old)
+			legacy.UpdateNew = append(legacy.UpdateNew, change.New)
+		}
+	}
+	return legacy
+}
+
+// filterOwnedAndIgnored drops any Change2 whose relevant endpoint(s) are
+// excluded by the ignore matcher, or (for deletes and updates) are not owned
+// by the configured TXT owner. It mirrors filterIgnored/filterOwnedRecords
+// but operates on the grouped Changes2 representation.
+func (c *Changes2) filterOwnedAndIgnored(ownerID, ownerIDOld string, migrate bool, ignoreMatch ignoreMatcher) *Changes2 {
+	filtered := &Changes2{}
+	for _, change := range c.Changes {
+		if change.Old != nil && ignoreMatch.Match(change.Old) {
+			continue
+		}
+		if change.New != nil && ignoreMatch.Match(change.New) {
+			continue
+		}
+		if change.Verb != ChangeVerbCreate {
+			if len(filterOwnedRecords(ownerID, ownerIDOld, migrate, []*endpoint.Endpoint{change.Old})) == 0 {
+				continue
+			}
+		}
+		filtered.Changes = append(filtered.Changes, change)
+	}
+	return filtered
+}
+
+// filterChanges2Deletes restricts the ChangeVerbDelete entries in changes to
+// those whose planKey is still present in allowedDeletes (the legacy
+// Changes.Delete slice after policies have run), leaving every other verb
+// untouched. It keeps Changes2 in sync with whatever a Policy dropped from
+// the per-record Changes.Delete.
+func filterChanges2Deletes(changes []*Change2, allowedDeletes []*endpoint.Endpoint) []*Change2 {
+	allowed := make(map[planKey]struct{}, len(allowedDeletes))
+	for _, ep := range allowedDeletes {
+		allowed[planKeyFor(ep)] = struct{}{}
+	}
+
+	filtered := changes[:0]
+	for _, change := range changes {
+		if change.Verb == ChangeVerbDelete {
+			if _, ok := allowed[change.PlanKey]; !ok {
+				continue
+			}
+		}
+		filtered = append(filtered, change)
+	}
+	return filtered
+}
+
+// diffTargets computes the set-difference between the desired and current
+// targets of a record group.
+func diffTargets(current, desired endpoint.Targets) (added, removed endpoint.Targets) {
+	currentSet := make(map[string]struct{}, len(current))
+	for _, t := range current {
+		currentSet[t] = struct{}{}
+	}
+	desiredSet := make(map[string]struct{}, len(desired))
+	for _, t := range desired {
+		desiredSet[t] = struct{}{}
+	}
+
+	for _, t := range desired {
+		if _, ok := currentSet[t]; !ok {
+			added = append(added, t)
+		}
+	}
+	for _, t := range current {
+		if _, ok := desiredSet[t]; !ok {
+			removed = append(removed, t)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestChanges2Lower(t *testing.T) {
+	create := &endpoint.Endpoint{DNSName: "create.example.com", RecordType: endpoint.RecordTypeA}
+	old := &endpoint.Endpoint{DNSName: "change.example.com", RecordType: endpoint.RecordTypeA}
+	new_ := &endpoint.Endpoint{DNSName: "change.example.com", RecordType: endpoint.RecordTypeA}
+	del := &endpoint.Endpoint{DNSName: "delete.example.com", RecordType: endpoint.RecordTypeA}
+
+	changes2 := &Changes2{Changes: []*Change2{
+		{Verb: ChangeVerbCreate, New: create},
+		{Verb: ChangeVerbChange, Old: old, New: new_},
+		{Verb: ChangeVerbDelete, Old: del},
+	}}
+
+	legacy := changes2.Lower()
+	assert.Equal(t, []*endpoint.Endpoint{create}, legacy.Create)
+	assert.Equal(t, []*endpoint.Endpoint{old}, legacy.UpdateOld)
+	assert.Equal(t, []*endpoint.Endpoint{new_}, legacy.UpdateNew)
+	assert.Equal(t, []*endpoint.Endpoint{del}, legacy.Delete)
+}
+
+func TestFilterChanges2DeletesDropsPolicyRejectedDeletes(t *testing.T) {
+	keptKey := planKey{dnsName: "kept.example.com.", recordType: endpoint.RecordTypeA}
+	droppedKey := planKey{dnsName: "dropped.example.com.", recordType: endpoint.RecordTypeA}
+	createKey := planKey{dnsName: "created.example.com.", recordType: endpoint.RecordTypeA}
+
+	changes := []*Change2{
+		{PlanKey: keptKey, Verb: ChangeVerbDelete, Old: &endpoint.Endpoint{DNSName: "kept.example.com", RecordType: endpoint.RecordTypeA}},
+		{PlanKey: droppedKey, Verb: ChangeVerbDelete, Old: &endpoint.Endpoint{DNSName: "dropped.example.com", RecordType: endpoint.RecordTypeA}},
+		{PlanKey: createKey, Verb: ChangeVerbCreate, New: &endpoint.Endpoint{DNSName: "created.example.com", RecordType: endpoint.RecordTypeA}},
+	}
+
+	// Only "kept" survived the policy's filtering of changes.Delete.
+	allowedDeletes := []*endpoint.Endpoint{{DNSName: "kept.example.com", RecordType: endpoint.RecordTypeA}}
+
+	filtered := filterChanges2Deletes(changes, allowedDeletes)
+
+	var verbs []ChangeVerb
+	var keys []planKey
+	for _, c := range filtered {
+		verbs = append(verbs, c.Verb)
+		keys = append(keys, c.PlanKey)
+	}
+	assert.ElementsMatch(t, []planKey{keptKey, createKey}, keys)
+	assert.NotContains(t, keys, droppedKey)
+	assert.Len(t, verbs, 2)
+}
+
+func TestChanges2FilterOwnedAndIgnored(t *testing.T) {
+	owned := &endpoint.Endpoint{
+		DNSName: "owned.example.com", RecordType: endpoint.RecordTypeA,
+		Labels: map[string]string{endpoint.OwnerLabelKey: "me"},
+	}
+	unowned := &endpoint.Endpoint{
+		DNSName: "unowned.example.com", RecordType: endpoint.RecordTypeA,
+		Labels: map[string]string{endpoint.OwnerLabelKey: "someone-else"},
+	}
+	ignored := &endpoint.Endpoint{DNSName: "ignored.example.com", RecordType: endpoint.RecordTypeA}
+
+	changes2 := &Changes2{Changes: []*Change2{
+		{PlanKey: planKeyFor(owned), Verb: ChangeVerbDelete, Old: owned},
+		{PlanKey: planKeyFor(unowned), Verb: ChangeVerbDelete, Old: unowned},
+		{PlanKey: planKeyFor(ignored), Verb: ChangeVerbCreate, New: ignored},
+	}}
+
+	ignoreMatch := newIgnoreMatcher([]IgnoreRule{{NamePattern: "ignored.example.com"}})
+
+	filtered := changes2.filterOwnedAndIgnored("me", "", false, ignoreMatch)
+
+	assert.Len(t, filtered.Changes, 1)
+	assert.Equal(t, owned, filtered.Changes[0].Old)
+}
+
+func TestCalculateChangesIsLoweredChanges2(t *testing.T) {
+	current := []*endpoint.Endpoint{
+		{DNSName: "update.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"},
+			Labels: map[string]string{endpoint.OwnerLabelKey: "me"}},
+		{DNSName: "delete.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"1.1.1.1"},
+			Labels: map[string]string{endpoint.OwnerLabelKey: "me"}},
+	}
+	desired := []*endpoint.Endpoint{
+		{DNSName: "update.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"2.2.2.2"}},
+		{DNSName: "create.example.com", RecordType: endpoint.RecordTypeA, Targets: endpoint.Targets{"3.3.3.3"}},
+	}
+
+	p := &Plan{Current: current, Desired: desired, ManagedRecords: []string{endpoint.RecordTypeA}, TXTOwner: "me"}
+	result := p.Calculate()
+
+	// With no policies, ignore rules or Missing records, and every current
+	// record owned by the plan's TXT owner, the legacy Changes must be
+	// exactly Changes2.Lower() - Calculate() derives one from the other.
+	lowered := result.Changes2.Lower()
+	assert.ElementsMatch(t, lowered.Create, result.Changes.Create)
+	assert.ElementsMatch(t, lowered.Delete, result.Changes.Delete)
+	assert.ElementsMatch(t, lowered.UpdateOld, result.Changes.UpdateOld)
+	assert.ElementsMatch(t, lowered.UpdateNew, result.Changes.UpdateNew)
+}
+
+func TestDiffTargets(t *testing.T) {
+	added, removed := diffTargets(
+		endpoint.Targets{"1.1.1.1", "2.2.2.2"},
+		endpoint.Targets{"2.2.2.2", "3.3.3.3"},
+	)
+	assert.ElementsMatch(t, endpoint.Targets{"3.3.3.3"}, added)
+	assert.ElementsMatch(t, endpoint.Targets{"1.1.1.1"}, removed)
+}
@@ -0,0 +1,190 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// ConflictResolver decides which of several candidate endpoints wins when
+// more than one desired record claims the same (dnsName, recordType,
+// setIdentifier), and what should happen to a current record once the
+// candidate that previously won is no longer among the desired records.
+type ConflictResolver interface {
+	// ResolveCreate picks the winning candidate when no current record exists yet.
+	ResolveCreate(candidates []*endpoint.Endpoint) *endpoint.Endpoint
+	// ResolveUpdate picks the winning candidate to replace an existing current record.
+	ResolveUpdate(current *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint
+	// ResolveDelete decides what to do with current once none of the
+	// original candidates claim its key any more. remainingCandidates holds
+	// the candidates still claiming the same dnsName and recordType under a
+	// different setIdentifier, minus those already chosen for their own
+	// setIdentifier (often empty, e.g. for a record with a single
+	// setIdentifier). It lets a resolver fall back to one of those instead
+	// of deleting the record outright.
+	ResolveDelete(current *endpoint.Endpoint, remainingCandidates []*endpoint.Endpoint) (Action, *endpoint.Endpoint)
+}
+
+// Action is the decision a ConflictResolver's ResolveDelete hook makes about
+// a current record whose winning candidate has disappeared.
+type Action int
+
+const (
+	// ActionDelete means the current record should be removed.
+	ActionDelete Action = iota
+	// ActionUpdate means the current record should be replaced by the
+	// fallback endpoint returned alongside this Action, rather than deleted.
+	ActionUpdate
+)
+
+// PerResource is the default ConflictResolver. ResolveUpdate prefers the
+// candidate that already occupies the dnsName so an existing winner is
+// "sticky"; everywhere else it deterministically picks the first candidate.
+type PerResource struct{}
+
+func (r PerResource) ResolveCreate(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	return candidates[0]
+}
+
+func (r PerResource) ResolveUpdate(current *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	for _, c := range candidates {
+		if c.DNSName == current.DNSName {
+			return c
+		}
+	}
+	return candidates[0]
+}
+
+func (r PerResource) ResolveDelete(_ *endpoint.Endpoint, remainingCandidates []*endpoint.Endpoint) (Action, *endpoint.Endpoint) {
+	if len(remainingCandidates) == 0 {
+		return ActionDelete, nil
+	}
+	return ActionUpdate, remainingCandidates[0]
+}
+
+// ResourceCreationTimestampKey is the label sources copy the owning
+// resource's creation timestamp (RFC 3339) into, for use by the
+// CreationTimestamp resolver.
+const ResourceCreationTimestampKey = "external-dns.alpha.kubernetes.io/resource-creation-timestamp"
+
+// CreationTimestamp resolves conflicts by picking the candidate whose
+// ResourceCreationTimestampKey label is the oldest, or the newest when
+// NewestWins is set. Candidates without a parseable timestamp sort last.
+type CreationTimestamp struct {
+	// NewestWins selects the most recently created candidate instead of the oldest.
+	NewestWins bool
+}
+
+func (r CreationTimestamp) ResolveCreate(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	return r.pick(candidates)
+}
+
+func (r CreationTimestamp) ResolveUpdate(_ *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	return r.pick(candidates)
+}
+
+func (r CreationTimestamp) ResolveDelete(_ *endpoint.Endpoint, remainingCandidates []*endpoint.Endpoint) (Action, *endpoint.Endpoint) {
+	if len(remainingCandidates) == 0 {
+		return ActionDelete, nil
+	}
+	return ActionUpdate, r.pick(remainingCandidates)
+}
+
+func (r CreationTimestamp) pick(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	sorted := make([]*endpoint.Endpoint, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, oki := creationTimestamp(sorted[i])
+		tj, okj := creationTimestamp(sorted[j])
+		if !oki {
+			return false
+		}
+		if !okj {
+			return true
+		}
+		if r.NewestWins {
+			return ti.After(tj)
+		}
+		return ti.Before(tj)
+	})
+	return sorted[0]
+}
+
+func creationTimestamp(ep *endpoint.Endpoint) (time.Time, bool) {
+	v, ok := ep.Labels[ResourceCreationTimestampKey]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// PriorityLabelKey is the label sources copy the
+// "external-dns.alpha.kubernetes.io/priority" annotation into, for use by
+// the Priority resolver.
+const PriorityLabelKey = "external-dns.alpha.kubernetes.io/priority"
+
+// Priority resolves conflicts using the PriorityLabelKey label; higher
+// values win. Candidates without a parseable priority are treated as
+// priority 0, so an unprioritized candidate loses only to a candidate with a
+// positive priority. On a tie the earliest candidate wins.
+type Priority struct{}
+
+func (r Priority) ResolveCreate(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	return r.pick(candidates)
+}
+
+func (r Priority) ResolveUpdate(_ *endpoint.Endpoint, candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	return r.pick(candidates)
+}
+
+func (r Priority) ResolveDelete(_ *endpoint.Endpoint, remainingCandidates []*endpoint.Endpoint) (Action, *endpoint.Endpoint) {
+	if len(remainingCandidates) == 0 {
+		return ActionDelete, nil
+	}
+	return ActionUpdate, r.pick(remainingCandidates)
+}
+
+func (r Priority) pick(candidates []*endpoint.Endpoint) *endpoint.Endpoint {
+	best := candidates[0]
+	bestPriority := priority(best)
+	for _, c := range candidates[1:] {
+		if p := priority(c); p > bestPriority {
+			best, bestPriority = c, p
+		}
+	}
+	return best
+}
+
+func priority(ep *endpoint.Endpoint) int {
+	v, ok := ep.Labels[PriorityLabelKey]
+	if !ok {
+		return 0
+	}
+	p, err := strconv.Atoi(v)
+	if err != nil {
+		return 0
+	}
+	return p
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestPerResourceResolveUpdatePrefersIncumbent(t *testing.T) {
+	current := &endpoint.Endpoint{DNSName: "foo.example.com", Targets: endpoint.Targets{"1.1.1.1"}}
+	incumbent := &endpoint.Endpoint{DNSName: "foo.example.com", Targets: endpoint.Targets{"2.2.2.2"}}
+	other := &endpoint.Endpoint{DNSName: "bar.example.com", Targets: endpoint.Targets{"3.3.3.3"}}
+
+	got := PerResource{}.ResolveUpdate(current, []*endpoint.Endpoint{other, incumbent})
+	assert.Same(t, incumbent, got)
+}
+
+func TestPerResourceResolveDeleteNoFallback(t *testing.T) {
+	current := &endpoint.Endpoint{DNSName: "foo.example.com"}
+	action, fallback := PerResource{}.ResolveDelete(current, nil)
+	assert.Equal(t, ActionDelete, action)
+	assert.Nil(t, fallback)
+}
+
+func TestPerResourceResolveDeleteFallsBackToRemainingCandidate(t *testing.T) {
+	current := &endpoint.Endpoint{DNSName: "foo.example.com"}
+	remaining := &endpoint.Endpoint{DNSName: "foo.example.com", SetIdentifier: "green"}
+	action, fallback := PerResource{}.ResolveDelete(current, []*endpoint.Endpoint{remaining})
+	assert.Equal(t, ActionUpdate, action)
+	assert.Same(t, remaining, fallback)
+}
+
+func TestCreationTimestampPicksOldestByDefault(t *testing.T) {
+	older := &endpoint.Endpoint{DNSName: "foo.example.com", Labels: map[string]string{ResourceCreationTimestampKey: "2020-01-01T00:00:00Z"}}
+	newer := &endpoint.Endpoint{DNSName: "foo.example.com", Labels: map[string]string{ResourceCreationTimestampKey: "2021-01-01T00:00:00Z"}}
+
+	got := CreationTimestamp{}.ResolveCreate([]*endpoint.Endpoint{newer, older})
+	assert.Same(t, older, got)
+}
+
+func TestCreationTimestampNewestWins(t *testing.T) {
+	older := &endpoint.Endpoint{DNSName: "foo.example.com", Labels: map[string]string{ResourceCreationTimestampKey: "2020-01-01T00:00:00Z"}}
+	newer := &endpoint.Endpoint{DNSName: "foo.example.com", Labels: map[string]string{ResourceCreationTimestampKey: "2021-01-01T00:00:00Z"}}
+
+	got := CreationTimestamp{NewestWins: true}.ResolveCreate([]*endpoint.Endpoint{older, newer})
+	assert.Same(t, newer, got)
+}
+
+func TestCreationTimestampUnparseableSortsLast(t *testing.T) {
+	withTimestamp := &endpoint.Endpoint{DNSName: "foo.example.com", Labels: map[string]string{ResourceCreationTimestampKey: "2020-01-01T00:00:00Z"}}
+	without := &endpoint.Endpoint{DNSName: "bar.example.com"}
+
+	got := CreationTimestamp{}.ResolveCreate([]*endpoint.Endpoint{without, withTimestamp})
+	assert.Same(t, withTimestamp, got)
+}
+
+func TestPriorityPicksHighest(t *testing.T) {
+	low := &endpoint.Endpoint{DNSName: "foo.example.com", Labels: map[string]string{PriorityLabelKey: "1"}}
+	high := &endpoint.Endpoint{DNSName: "foo.example.com", Labels: map[string]string{PriorityLabelKey: "10"}}
+	unset := &endpoint.Endpoint{DNSName: "foo.example.com"}
+
+	got := Priority{}.ResolveCreate([]*endpoint.Endpoint{low, unset, high})
+	assert.Same(t, high, got)
+}
+
+// TestResolveDeleteDoesNotBorrowAnotherRowsWinner guards against a fallback
+// candidate being "borrowed" from a sibling setIdentifier's row that has
+// already claimed it for its own Create/Update. Without excluding winners,
+// this scenario would produce both a Create for the green row's winner and
+// a contradictory Update of the blue row onto that very same record.
+func TestResolveDeleteDoesNotBorrowAnotherRowsWinner(t *testing.T) {
+	current := &endpoint.Endpoint{
+		DNSName: "weighted.example.com", RecordType: endpoint.RecordTypeA,
+		SetIdentifier: "blue", Targets: endpoint.Targets{"1.1.1.1"},
+		Labels: map[string]string{endpoint.OwnerLabelKey: "me"},
+	}
+	greenWinner := &endpoint.Endpoint{
+		DNSName: "weighted.example.com", RecordType: endpoint.RecordTypeA,
+		SetIdentifier: "green", Targets: endpoint.Targets{"2.2.2.2"},
+	}
+
+	p := &Plan{
+		Current:          []*endpoint.Endpoint{current},
+		Desired:          []*endpoint.Endpoint{greenWinner},
+		ManagedRecords:   []string{endpoint.RecordTypeA},
+		ConflictResolver: PerResource{},
+		TXTOwner:         "me",
+	}
+
+	result := p.Calculate()
+
+	assert.Len(t, result.Changes.Delete, 1, "blue must be deleted, not updated onto green's winner")
+	assert.Same(t, current, result.Changes.Delete[0])
+	assert.Len(t, result.Changes.Create, 1)
+	assert.Same(t, greenWinner, result.Changes.Create[0])
+	assert.Empty(t, result.Changes.UpdateNew)
+}
+
+// TestResolveDeleteFallsBackToCandidateThatLostItsOwnRow exercises the
+// genuine fallback case: a sibling setIdentifier has more than one
+// candidate, so the one that did *not* win its own row's ResolveCreate is
+// still free to be offered as a ResolveDelete fallback for a different
+// setIdentifier.
+func TestResolveDeleteFallsBackToCandidateThatLostItsOwnRow(t *testing.T) {
+	current := &endpoint.Endpoint{
+		DNSName: "weighted.example.com", RecordType: endpoint.RecordTypeA,
+		SetIdentifier: "blue", Targets: endpoint.Targets{"1.1.1.1"},
+		Labels: map[string]string{endpoint.OwnerLabelKey: "me"},
+	}
+	greenWinner := &endpoint.Endpoint{
+		DNSName: "weighted.example.com", RecordType: endpoint.RecordTypeA,
+		SetIdentifier: "green", Targets: endpoint.Targets{"2.2.2.2"},
+	}
+	greenLoser := &endpoint.Endpoint{
+		DNSName: "weighted.example.com", RecordType: endpoint.RecordTypeA,
+		SetIdentifier: "green", Targets: endpoint.Targets{"3.3.3.3"},
+	}
+
+	p := &Plan{
+		Current:          []*endpoint.Endpoint{current},
+		Desired:          []*endpoint.Endpoint{greenWinner, greenLoser},
+		ManagedRecords:   []string{endpoint.RecordTypeA},
+		ConflictResolver: PerResource{},
+		TXTOwner:         "me",
+	}
+
+	result := p.Calculate()
+
+	assert.Empty(t, result.Changes.Delete, "blue should fall back to green's unused candidate instead of deleting")
+	assert.Len(t, result.Changes.Create, 1)
+	assert.Same(t, greenWinner, result.Changes.Create[0])
+	require.Len(t, result.Changes.UpdateNew, 1)
+	assert.Same(t, greenLoser, result.Changes.UpdateNew[0])
+}
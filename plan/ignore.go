@@ -0,0 +1,120 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"path"
+
+	log "github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// IgnoreRule describes a set of records that the planner must never touch,
+// regardless of TXT ownership. It allows external-dns to coexist with
+// hand-managed records (MX, legacy A records, vendor-owned subtrees) in a
+// zone without requiring a TXT owner record for every name.
+type IgnoreRule struct {
+	// NamePattern is a DNS glob (e.g. "*.legacy.example.com", "foo?.example.com")
+	// matched against the record's normalized DNS name. Empty matches any name.
+	NamePattern string
+	// RecordType restricts the rule to a single record type (e.g. "A"). Empty matches any type.
+	RecordType string
+	// TargetPattern is a glob matched against each of the record's targets.
+	// Empty matches any target.
+	TargetPattern string
+}
+
+// ignoreMatcher evaluates a []IgnoreRule against endpoints.
+type ignoreMatcher struct {
+	rules []IgnoreRule
+}
+
+// newIgnoreMatcher wraps the ignore rules for use by Match. The patterns are
+// kept as written and are not pre-compiled: each Match call parses them
+// again through path.Match.
+func newIgnoreMatcher(rules []IgnoreRule) ignoreMatcher {
+	return ignoreMatcher{rules: rules}
+}
+
+// Match reports whether the given endpoint is covered by any of the compiled
+// ignore rules, and therefore must be excluded from the plan entirely.
+func (m ignoreMatcher) Match(ep *endpoint.Endpoint) bool {
+	if len(m.rules) == 0 {
+		return false
+	}
+	name := normalizeDNSName(ep.DNSName)
+	for _, rule := range m.rules {
+		if rule.RecordType != "" && rule.RecordType != ep.RecordType {
+			continue
+		}
+		if rule.NamePattern != "" && !globMatch(rule.NamePattern, name) {
+			continue
+		}
+		if rule.TargetPattern != "" && !matchesAnyTarget(rule.TargetPattern, ep) {
+			continue
+		}
+		log.Debugf("ignoring record %s (%s) because it matches ignore rule %+v", ep.DNSName, ep.RecordType, rule)
+		return true
+	}
+	return false
+}
+
+// MatchAny reports whether any endpoint in eps matches the compiled ignore rules.
+func (m ignoreMatcher) MatchAny(eps []*endpoint.Endpoint) bool {
+	for _, ep := range eps {
+		if m.Match(ep) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyTarget(pattern string, ep *endpoint.Endpoint) bool {
+	for _, target := range ep.Targets {
+		if globMatch(pattern, normalizeDNSName(target)) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch evaluates a DNS glob pattern ("*" and "?" wildcards) against a
+// normalized (lower-cased, trailing-dot) DNS name or target.
+func globMatch(pattern, name string) bool {
+	ok, err := path.Match(normalizeDNSName(pattern), name)
+	if err != nil {
+		log.Debugf("ignoring malformed ignore-rule pattern %q: %v", pattern, err)
+		return false
+	}
+	return ok
+}
+
+// filterIgnored removes any endpoint matched by the ignore matcher from eps.
+func filterIgnored(eps []*endpoint.Endpoint, matcher ignoreMatcher) []*endpoint.Endpoint {
+	if len(matcher.rules) == 0 {
+		return eps
+	}
+	filtered := make([]*endpoint.Endpoint, 0, len(eps))
+	for _, ep := range eps {
+		if matcher.Match(ep) {
+			continue
+		}
+		filtered = append(filtered, ep)
+	}
+	return filtered
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestIgnoreMatcherNamePattern(t *testing.T) {
+	matcher := newIgnoreMatcher([]IgnoreRule{{NamePattern: "*.legacy.example.com"}})
+
+	assert.True(t, matcher.Match(&endpoint.Endpoint{DNSName: "foo.legacy.example.com", RecordType: endpoint.RecordTypeA}))
+	assert.False(t, matcher.Match(&endpoint.Endpoint{DNSName: "foo.example.com", RecordType: endpoint.RecordTypeA}))
+}
+
+func TestIgnoreMatcherRecordTypeScoped(t *testing.T) {
+	matcher := newIgnoreMatcher([]IgnoreRule{{NamePattern: "foo.example.com", RecordType: endpoint.RecordTypeMX}})
+
+	assert.True(t, matcher.Match(&endpoint.Endpoint{DNSName: "foo.example.com", RecordType: endpoint.RecordTypeMX}))
+	assert.False(t, matcher.Match(&endpoint.Endpoint{DNSName: "foo.example.com", RecordType: endpoint.RecordTypeA}))
+}
+
+func TestIgnoreMatcherTargetPattern(t *testing.T) {
+	matcher := newIgnoreMatcher([]IgnoreRule{{TargetPattern: "*.vendor.example.com"}})
+
+	assert.True(t, matcher.Match(&endpoint.Endpoint{
+		DNSName: "foo.example.com", RecordType: endpoint.RecordTypeCNAME,
+		Targets: endpoint.Targets{"app.vendor.example.com"},
+	}))
+	assert.False(t, matcher.Match(&endpoint.Endpoint{
+		DNSName: "foo.example.com", RecordType: endpoint.RecordTypeCNAME,
+		Targets: endpoint.Targets{"app.example.com"},
+	}))
+}
+
+func TestIgnoreMatcherNoRulesMatchesNothing(t *testing.T) {
+	matcher := newIgnoreMatcher(nil)
+	assert.False(t, matcher.Match(&endpoint.Endpoint{DNSName: "foo.example.com", RecordType: endpoint.RecordTypeA}))
+}
+
+func TestIgnoreMatcherMalformedPatternIsIgnored(t *testing.T) {
+	matcher := newIgnoreMatcher([]IgnoreRule{{NamePattern: "["}})
+	assert.False(t, matcher.Match(&endpoint.Endpoint{DNSName: "foo.example.com", RecordType: endpoint.RecordTypeA}))
+}
+
+func TestFilterIgnoredRemovesMatchedEndpoints(t *testing.T) {
+	matcher := newIgnoreMatcher([]IgnoreRule{{NamePattern: "*.legacy.example.com"}})
+	eps := []*endpoint.Endpoint{
+		{DNSName: "foo.legacy.example.com", RecordType: endpoint.RecordTypeA},
+		{DNSName: "foo.example.com", RecordType: endpoint.RecordTypeA},
+	}
+
+	filtered := filterIgnored(eps, matcher)
+
+	assert.Len(t, filtered, 1)
+	assert.Equal(t, "foo.example.com", filtered[0].DNSName)
+}
+
+func TestMatchAny(t *testing.T) {
+	matcher := newIgnoreMatcher([]IgnoreRule{{NamePattern: "*.legacy.example.com"}})
+	eps := []*endpoint.Endpoint{
+		{DNSName: "foo.example.com", RecordType: endpoint.RecordTypeA},
+		{DNSName: "foo.legacy.example.com", RecordType: endpoint.RecordTypeA},
+	}
+
+	assert.True(t, matcher.MatchAny(eps))
+	assert.False(t, matcher.MatchAny(eps[:1]))
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package plan
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -45,6 +46,10 @@ type Plan struct {
 	// List of changes necessary to move towards desired state
 	// Populated after calling Calculate()
 	Changes *Changes
+	// Changes2 is the record-group (diff2) view of Changes, populated whenever
+	// Calculate() succeeds. Providers implementing ProviderSupportsChanges2
+	// should consume this instead of the lowered Changes.
+	Changes2 *Changes2
 	// DomainFilter matches DNS names
 	DomainFilter endpoint.DomainFilterInterface
 	// Property comparator compares custom properties of providers
@@ -59,6 +64,54 @@ type Plan struct {
 	TXTOwnerMigrate bool
 	// old txt-owner whitch needed to modify
 	TXTOwnerOld string
+	// SafetyLimits bound how many records a single Calculate() pass is allowed to delete
+	SafetyLimits SafetyLimits
+	// IgnoreRules lists records the planner must never create, update or delete,
+	// even if they would otherwise be touched by the TXT owner check.
+	IgnoreRules []IgnoreRule
+	// ConflictResolver picks a winner when multiple desired records claim the
+	// same (dnsName, recordType, setIdentifier). Defaults to PerResource{}.
+	ConflictResolver ConflictResolver
+	// Report is the structured, per-planKey account of the last Calculate() call.
+	Report *PlanReport
+}
+
+// SafetyLimits bounds the size of the delete set that a single Calculate() call
+// is allowed to produce. It exists to protect against a misconfigured source
+// (e.g. an empty Service list or a broken informer) wiping out a zone.
+type SafetyLimits struct {
+	// MaxDeletePercent is the maximum percentage (0-100) of p.Current that may
+	// be deleted in a single pass. Zero means "no percentage limit".
+	MaxDeletePercent int
+	// MaxDeleteAbsolute is the maximum absolute number of records that may be
+	// deleted in a single pass. Zero means "no absolute limit".
+	MaxDeleteAbsolute int
+	// MinRetained is the minimum number of records that must remain after the
+	// deletes are applied. Zero means "no minimum".
+	MinRetained int
+	// Force disables the limits above.
+	Force bool
+}
+
+// exceeds reports whether the given number of current records and proposed
+// deletes violates the configured limits. It returns the violated reason, if any.
+func (s SafetyLimits) exceeds(currentCount, deleteCount int) (string, bool) {
+	if s.Force {
+		return "", false
+	}
+	if s.MaxDeleteAbsolute > 0 && deleteCount > s.MaxDeleteAbsolute {
+		return fmt.Sprintf("delete count %d exceeds MaxDeleteAbsolute %d", deleteCount, s.MaxDeleteAbsolute), true
+	}
+	if s.MaxDeletePercent > 0 && currentCount > 0 {
+		percent := deleteCount * 100 / currentCount
+		if deleteCount*100 > s.MaxDeletePercent*currentCount {
+			return fmt.Sprintf("delete count %d (%d%% of %d current records) exceeds MaxDeletePercent %d", deleteCount, percent, currentCount, s.MaxDeletePercent), true
+		}
+	}
+	if s.MinRetained > 0 && currentCount-deleteCount < s.MinRetained {
+		return fmt.Sprintf("retaining %d records would fall below MinRetained %d", currentCount-deleteCount, s.MinRetained), true
+	}
+	return "", false
 }
 
 // Changes holds lists of actions to be executed by dns providers
@@ -71,6 +124,10 @@ type Changes struct {
 	UpdateNew []*endpoint.Endpoint
 	// Records that need to be deleted
 	Delete []*endpoint.Endpoint
+	// Aborted holds the reason Calculate() refused to emit the changes above
+	// because they violated the Plan's SafetyLimits. When Aborted is non-empty,
+	// Create/UpdateOld/UpdateNew/Delete are left empty and must not be applied.
+	Aborted string
 }
 
 // planKey is a key for a row in `planTable`.
@@ -80,6 +137,28 @@ type planKey struct {
 	recordType    string
 }
 
+// planKeyJSON mirrors planKey with exported fields, so that a planKey (used
+// unexported as a map key throughout the planner) can still round-trip
+// through JSON when it's embedded in a PlanReport or Changes2 entry.
+type planKeyJSON struct {
+	DNSName       string `json:"dnsName"`
+	SetIdentifier string `json:"setIdentifier,omitempty"`
+	RecordType    string `json:"recordType"`
+}
+
+func (k planKey) MarshalJSON() ([]byte, error) {
+	return json.Marshal(planKeyJSON{DNSName: k.dnsName, SetIdentifier: k.setIdentifier, RecordType: k.recordType})
+}
+
+func (k *planKey) UnmarshalJSON(data []byte) error {
+	var v planKeyJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	k.dnsName, k.setIdentifier, k.recordType = v.DNSName, v.SetIdentifier, v.RecordType
+	return nil
+}
+
 // planTable is a supplementary struct for Plan
 // each row correspond to a planKey -> (current record + all desired records)
 /*
@@ -96,10 +175,25 @@ bar.com |                | [->191.1.1.1, ->190.1.1.1]  |  = create (bar.com -> 1
 type planTable struct {
 	rows     map[planKey]*planTableRow
 	resolver ConflictResolver
+	// bySetID indexes every candidate by the (dnsName, recordType) it claims,
+	// regardless of setIdentifier, so that a row whose own setIdentifier has
+	// lost all its candidates can still be offered the candidates of a
+	// sibling setIdentifier as a ResolveDelete fallback. Without this, a
+	// row's own candidates are by definition empty whenever ResolveDelete is
+	// reached, and there is never anything for a resolver to fall back to.
+	bySetID map[dnsTypeKey][]*endpoint.Endpoint
 }
 
-func newPlanTable() planTable { // TODO: make resolver configurable
-	return planTable{map[planKey]*planTableRow{}, PerResource{}}
+// dnsTypeKey groups planKeys that share a dnsName and recordType across
+// different setIdentifiers (e.g. the weighted/multi-value variants of a
+// single weighted-routing record).
+type dnsTypeKey struct {
+	dnsName    string
+	recordType string
+}
+
+func newPlanTable(resolver ConflictResolver) planTable {
+	return planTable{map[planKey]*planTableRow{}, resolver, map[dnsTypeKey][]*endpoint.Endpoint{}}
 }
 
 // planTableRow
@@ -122,20 +216,74 @@ func (t planTable) addCurrent(e *endpoint.Endpoint) {
 func (t planTable) addCandidate(e *endpoint.Endpoint) {
 	key := t.newPlanKey(e)
 	t.rows[key].candidates = append(t.rows[key].candidates, e)
+	dtKey := dnsTypeKey{dnsName: key.dnsName, recordType: key.recordType}
+	t.bySetID[dtKey] = append(t.bySetID[dtKey], e)
 }
 
-func (t *planTable) newPlanKey(e *endpoint.Endpoint) planKey {
-	key := planKey{
-		dnsName:       normalizeDNSName(e.DNSName),
-		setIdentifier: e.SetIdentifier,
-		recordType:    e.RecordType,
+// fallbackCandidatesFor returns the candidates claiming key's (dnsName,
+// recordType) under a different setIdentifier, excluding any candidate in
+// winners. It is passed to ConflictResolver.ResolveDelete as
+// remainingCandidates: key's own candidates are empty by the time
+// ResolveDelete is called, so this is the only way a resolver can ever
+// receive a non-empty fallback list.
+//
+// winners must hold every candidate that already won ResolveCreate or
+// ResolveUpdate for its own row in this Calculate() pass. Without excluding
+// them, this row could "borrow" another row's winner as its own fallback,
+// producing a Create for that winner's row and a contradictory Update of
+// this row onto the very same record.
+func (t planTable) fallbackCandidatesFor(key planKey, winners map[*endpoint.Endpoint]bool) []*endpoint.Endpoint {
+	var fallback []*endpoint.Endpoint
+	for _, c := range t.bySetID[dnsTypeKey{dnsName: key.dnsName, recordType: key.recordType}] {
+		if c.SetIdentifier == key.setIdentifier {
+			continue
+		}
+		if winners[c] {
+			continue
+		}
+		fallback = append(fallback, c)
+	}
+	return fallback
+}
+
+// winningCandidates computes, for every row that resolves its own winner
+// (i.e. every row that isn't itself a ResolveDelete candidate), which
+// endpoint that winner is. It mirrors the branching in Calculate() for the
+// create/update/migrate cases so fallbackCandidatesFor never offers a
+// candidate that's already spoken for.
+func (t planTable) winningCandidates(p *Plan) map[*endpoint.Endpoint]bool {
+	winners := make(map[*endpoint.Endpoint]bool, len(t.rows))
+	for _, row := range t.rows {
+		switch {
+		case row.current == nil:
+			winners[t.resolver.ResolveCreate(row.candidates)] = true
+		case len(row.candidates) > 0:
+			if p.TXTOwnerMigrate && row.current.Labels[endpoint.OwnerLabelKey] == p.TXTOwnerOld {
+				continue
+			}
+			winners[t.resolver.ResolveUpdate(row.current, row.candidates)] = true
+		}
 	}
+	return winners
+}
+
+func (t *planTable) newPlanKey(e *endpoint.Endpoint) planKey {
+	key := planKeyFor(e)
 	if _, ok := t.rows[key]; !ok {
 		t.rows[key] = &planTableRow{}
 	}
 	return key
 }
 
+// planKeyFor derives the planKey for an endpoint without requiring a planTable.
+func planKeyFor(e *endpoint.Endpoint) planKey {
+	return planKey{
+		dnsName:       normalizeDNSName(e.DNSName),
+		setIdentifier: e.SetIdentifier,
+		recordType:    e.RecordType,
+	}
+}
+
 func (c *Changes) HasChanges() bool {
 	if len(c.Create) > 0 || len(c.Delete) > 0 {
 		return true
@@ -147,28 +295,70 @@ func (c *Changes) HasChanges() bool {
 // state. It then passes those changes to the current policy for further
 // processing. It returns a copy of Plan with the changes populated.
 func (p *Plan) Calculate() *Plan {
-	t := newPlanTable()
+	if p.ConflictResolver == nil {
+		p.ConflictResolver = PerResource{}
+	}
+	t := newPlanTable(p.ConflictResolver)
 
 	if p.DomainFilter == nil {
 		p.DomainFilter = endpoint.MatchAllDomainFilters(nil)
 	}
+	ignoreMatch := newIgnoreMatcher(p.IgnoreRules)
 
-	for _, current := range filterRecordsForPlan(p.Current, p.DomainFilter, p.ManagedRecords) {
+	for _, current := range filterRecordsForPlan(p.Current, p.DomainFilter, p.ManagedRecords, ignoreMatch) {
 		t.addCurrent(current)
 	}
-	for _, desired := range filterRecordsForPlan(p.Desired, p.DomainFilter, p.ManagedRecords) {
+	for _, desired := range filterRecordsForPlan(p.Desired, p.DomainFilter, p.ManagedRecords, ignoreMatch) {
 		t.addCandidate(desired)
 	}
 
-	changes := &Changes{}
+	changes2 := &Changes2{}
+	report := &PlanReport{}
+	resolverName := fmt.Sprintf("%T", t.resolver)
+	winners := t.winningCandidates(p)
 	var hasMig bool
 
-	for _, row := range t.rows {
+	for _, ep := range append(append([]*endpoint.Endpoint{}, p.Current...), p.Desired...) {
+		if ignoreMatch.Match(ep) {
+			report.add(&ReportEntry{
+				PlanKey:  planKey{dnsName: normalizeDNSName(ep.DNSName), setIdentifier: ep.SetIdentifier, recordType: ep.RecordType},
+				Decision: ReportDecisionNoop, Reason: "ignored by filter",
+			})
+		}
+	}
+
+	for key, row := range t.rows {
 		if row.current == nil { // dns name not taken
-			changes.Create = append(changes.Create, t.resolver.ResolveCreate(row.candidates))
+			created := t.resolver.ResolveCreate(row.candidates)
+			changes2.Changes = append(changes2.Changes, &Change2{PlanKey: key, Verb: ChangeVerbCreate, New: created})
+			report.add(&ReportEntry{
+				PlanKey: key, Candidates: candidateSources(row.candidates), Resolver: resolverName,
+				Decision: ReportDecisionCreate, Reason: "no current record for this key",
+			})
 		}
 		if row.current != nil && len(row.candidates) == 0 {
-			changes.Delete = append(changes.Delete, row.current)
+			action, fallback := t.resolver.ResolveDelete(row.current, t.fallbackCandidatesFor(key, winners))
+			if action == ActionUpdate && fallback != nil {
+				inheritOwner(row.current, fallback)
+				added, removed := diffTargets(row.current.Targets, fallback.Targets)
+				changes2.Changes = append(changes2.Changes, &Change2{
+					PlanKey: key, Verb: ChangeVerbChange, Old: row.current, New: fallback,
+					TargetsAdded: added, TargetsRemoved: removed,
+					TTLChanged:              shouldUpdateTTL(fallback, row.current),
+					ProviderSpecificChanged: p.shouldUpdateProviderSpecific(fallback, row.current),
+				})
+				report.add(&ReportEntry{
+					PlanKey: key, Resolver: resolverName, Decision: ReportDecisionUpdate,
+					Reason:       "conflict resolver fell back to a lower-priority candidate",
+					TargetsAdded: added, TargetsRemoved: removed,
+				})
+			} else {
+				changes2.Changes = append(changes2.Changes, &Change2{PlanKey: key, Verb: ChangeVerbDelete, Old: row.current})
+				report.add(&ReportEntry{
+					PlanKey: key, Resolver: resolverName, Decision: ReportDecisionDelete,
+					Reason: "no desired candidates remain for this key",
+				})
+			}
 		}
 
 		// Change the specified old txt-owner to the new txt-owner (if TXTOwnerMigrate==true and set the from-txt-owner)
@@ -184,30 +374,92 @@ func (p *Plan) Calculate() *Plan {
 				"dnsName":       row.current.DNSName,
 				"recordType":    row.current.RecordType,
 			}).Info("Found record to migrate")
-			changes.UpdateNew = append(changes.UpdateNew, update)
-			changes.UpdateOld = append(changes.UpdateOld, row.current)
+			added, removed := diffTargets(row.current.Targets, update.Targets)
+			changes2.Changes = append(changes2.Changes, &Change2{
+				PlanKey: key, Verb: ChangeVerbChange, Old: row.current, New: update,
+				TargetsAdded: added, TargetsRemoved: removed,
+			})
+			report.add(&ReportEntry{
+				PlanKey: key, Candidates: candidateSources(row.candidates), Resolver: resolverName,
+				Decision: ReportDecisionUpdate, Reason: "owner migration",
+			})
 			continue
 		}
 
 		// TODO: allows record type change, which might not be supported by all dns providers
 		if row.current != nil && len(row.candidates) > 0 { // dns name is taken
 			update := t.resolver.ResolveUpdate(row.current, row.candidates)
+			ttlChanged := shouldUpdateTTL(update, row.current)
+			targetsChanged := targetChanged(update, row.current)
+			providerSpecificChanged := p.shouldUpdateProviderSpecific(update, row.current)
 			// compare "update" to "current" to figure out if actual update is required
-			if shouldUpdateTTL(update, row.current) || targetChanged(update, row.current) || p.shouldUpdateProviderSpecific(update, row.current) {
+			if ttlChanged || targetsChanged || providerSpecificChanged {
 				inheritOwner(row.current, update)
-				changes.UpdateNew = append(changes.UpdateNew, update)
-				changes.UpdateOld = append(changes.UpdateOld, row.current)
+				added, removed := diffTargets(row.current.Targets, update.Targets)
+				changes2.Changes = append(changes2.Changes, &Change2{
+					PlanKey: key, Verb: ChangeVerbChange, Old: row.current, New: update,
+					TargetsAdded: added, TargetsRemoved: removed,
+					TTLChanged: ttlChanged, ProviderSpecificChanged: providerSpecificChanged,
+				})
+				report.add(&ReportEntry{
+					PlanKey: key, Candidates: candidateSources(row.candidates), Resolver: resolverName,
+					Decision: ReportDecisionUpdate, Reason: updateReason(ttlChanged, targetsChanged, providerSpecificChanged),
+					TargetsAdded: added, TargetsRemoved: removed,
+				})
+			} else {
+				report.add(&ReportEntry{
+					PlanKey: key, Candidates: candidateSources(row.candidates), Resolver: resolverName,
+					Decision: ReportDecisionNoop, Reason: "no target, ttl or provider-specific changes detected",
+				})
 			}
 			continue
 		}
 	}
+	// changes2 is the single source of truth for the diff computed above;
+	// changes starts out as its lowered view. The Missing TXT records
+	// appended below are added to changes.Create only, not to changes2.
+	changes := changes2.Lower()
+
+	prePolicyDeletes := changes.Delete
 	for _, pol := range p.Policies {
 		changes = pol.Apply(changes)
 	}
+	reportPolicyDroppedDeletes(report, prePolicyDeletes, changes.Delete)
+	// Policies only ever drop entries from changes.Delete, so re-derive the
+	// set of keys still allowed to be deleted and drop any Changes2 delete
+	// entry that policy rejected. Without this, a provider consuming Changes2
+	// would still receive ChangeVerbDelete entries for records an
+	// upsert-only/no-delete policy told us never to delete.
+	changes2.Changes = filterChanges2Deletes(changes2.Changes, changes.Delete)
 
 	// Handle the migration of the TXT records created before the new format (introduced in v0.12.0)
 	if len(p.Missing) > 0 {
-		changes.Create = append(changes.Create, filterRecordsForPlan(p.Missing, p.DomainFilter, append(p.ManagedRecords, endpoint.RecordTypeTXT))...)
+		changes.Create = append(changes.Create, filterRecordsForPlan(p.Missing, p.DomainFilter, append(p.ManagedRecords, endpoint.RecordTypeTXT), ignoreMatch)...)
+	}
+
+	// Ignore rules take precedence over the TXT owner check: a record that
+	// matches an ignore rule is dropped here before filterOwnedRecords runs.
+	changes.Create = filterIgnored(changes.Create, ignoreMatch)
+	changes.UpdateOld = filterIgnored(changes.UpdateOld, ignoreMatch)
+	changes.UpdateNew = filterIgnored(changes.UpdateNew, ignoreMatch)
+	changes.Delete = filterIgnored(changes.Delete, ignoreMatch)
+
+	deletes := filterOwnedRecords(p.TXTOwner, p.TXTOwnerOld, p.TXTOwnerMigrate, changes.Delete)
+	changes2 = changes2.filterOwnedAndIgnored(p.TXTOwner, p.TXTOwnerOld, p.TXTOwnerMigrate, ignoreMatch)
+
+	if reason, aborted := p.SafetyLimits.exceeds(len(p.Current), len(deletes)); aborted {
+		log.WithFields(log.Fields{
+			"currentRecords": len(p.Current),
+			"deleteCount":    len(deletes),
+			"deletedNames":   deletedDNSNames(deletes),
+		}).Warnf("Aborting plan: %s", reason)
+		return &Plan{
+			Current:  p.Current,
+			Desired:  p.Desired,
+			Changes:  &Changes{Aborted: reason},
+			Changes2: &Changes2{},
+			Report:   report,
+		}
 	}
 
 	plan := &Plan{
@@ -217,15 +469,27 @@ func (p *Plan) Calculate() *Plan {
 			Create:    changes.Create,
 			UpdateNew: filterOwnedRecords(p.TXTOwner, p.TXTOwnerOld, p.TXTOwnerMigrate, changes.UpdateNew),
 			UpdateOld: filterOwnedRecords(p.TXTOwner, p.TXTOwnerOld, p.TXTOwnerMigrate, changes.UpdateOld),
-			Delete:    filterOwnedRecords(p.TXTOwner, p.TXTOwnerOld, p.TXTOwnerMigrate, changes.Delete),
+			Delete:    deletes,
 		},
+		Changes2:       changes2,
 		ManagedRecords: []string{endpoint.RecordTypeA, endpoint.RecordTypeAAAA, endpoint.RecordTypeCNAME},
 		HasMig:         hasMig,
+		Report:         report,
 	}
 
 	return plan
 }
 
+// deletedDNSNames extracts the DNS names of the records slated for deletion,
+// for use in the structured log emitted when a plan is aborted by SafetyLimits.
+func deletedDNSNames(deletes []*endpoint.Endpoint) []string {
+	names := make([]string, 0, len(deletes))
+	for _, ep := range deletes {
+		names = append(names, ep.DNSName)
+	}
+	return names
+}
+
 func inheritOwner(from, to *endpoint.Endpoint) {
 	if to.Labels == nil {
 		to.Labels = map[string]string{}
@@ -240,6 +504,22 @@ func targetChanged(desired, current *endpoint.Endpoint) bool {
 	return !desired.Targets.Same(current.Targets)
 }
 
+// updateReason renders a short, human-readable explanation for an update
+// decision, for use in PlanReport entries.
+func updateReason(ttlChanged, targetsChanged, providerSpecificChanged bool) string {
+	var reasons []string
+	if targetsChanged {
+		reasons = append(reasons, "target changed")
+	}
+	if ttlChanged {
+		reasons = append(reasons, "ttl changed")
+	}
+	if providerSpecificChanged {
+		reasons = append(reasons, "provider-specific property changed")
+	}
+	return strings.Join(reasons, ", ")
+}
+
 func shouldUpdateTTL(desired, current *endpoint.Endpoint) bool {
 	if !desired.RecordTTL.IsConfigured() {
 		return false
@@ -305,12 +585,13 @@ func filterOwnedRecords(ownerID string, ownerIDOld string, migrate bool, eps []*
 
 // filterRecordsForPlan removes records that are not relevant to the planner.
 // Currently this just removes TXT records to prevent them from being
-// deleted erroneously by the planner (only the TXT registry should do this.)
+// deleted erroneously by the planner (only the TXT registry should do this.),
+// and records matched by the configured IgnoreRules.
 //
 // Per RFC 1034, CNAME records conflict with all other records - it is the
 // only record with this property. The behavior of the planner may need to be
 // made more sophisticated to codify this.
-func filterRecordsForPlan(records []*endpoint.Endpoint, domainFilter endpoint.DomainFilterInterface, managedRecords []string) []*endpoint.Endpoint {
+func filterRecordsForPlan(records []*endpoint.Endpoint, domainFilter endpoint.DomainFilterInterface, managedRecords []string, ignoreMatch ignoreMatcher) []*endpoint.Endpoint {
 	filtered := []*endpoint.Endpoint{}
 
 	for _, record := range records {
@@ -319,6 +600,9 @@ func filterRecordsForPlan(records []*endpoint.Endpoint, domainFilter endpoint.Do
 			log.Debugf("ignoring record %s that does not match domain filter", record.DNSName)
 			continue
 		}
+		if ignoreMatch.Match(record) {
+			continue
+		}
 		if IsManagedRecord(record.RecordType, managedRecords) {
 			filtered = append(filtered, record)
 		}
@@ -0,0 +1,112 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestSafetyLimitsExceedsMaxDeletePercent(t *testing.T) {
+	limits := SafetyLimits{MaxDeletePercent: 20}
+
+	reason, aborted := limits.exceeds(10, 3)
+	assert.True(t, aborted)
+	assert.Contains(t, reason, "MaxDeletePercent")
+
+	reason, aborted = limits.exceeds(10, 2)
+	assert.False(t, aborted)
+	assert.Empty(t, reason)
+}
+
+func TestSafetyLimitsExceedsMaxDeleteAbsolute(t *testing.T) {
+	limits := SafetyLimits{MaxDeleteAbsolute: 5}
+
+	_, aborted := limits.exceeds(100, 6)
+	assert.True(t, aborted)
+
+	_, aborted = limits.exceeds(100, 5)
+	assert.False(t, aborted)
+}
+
+func TestSafetyLimitsExceedsMinRetained(t *testing.T) {
+	limits := SafetyLimits{MinRetained: 3}
+
+	_, aborted := limits.exceeds(5, 3)
+	assert.True(t, aborted)
+
+	_, aborted = limits.exceeds(5, 2)
+	assert.False(t, aborted)
+}
+
+func TestSafetyLimitsForceDisablesAllLimits(t *testing.T) {
+	limits := SafetyLimits{MaxDeleteAbsolute: 1, MaxDeletePercent: 1, MinRetained: 100, Force: true}
+
+	_, aborted := limits.exceeds(10, 10)
+	assert.False(t, aborted)
+}
+
+func TestCalculateAbortsOnSafetyLimits(t *testing.T) {
+	current := []*endpoint.Endpoint{
+		{DNSName: "a.example.com", RecordType: endpoint.RecordTypeA, Labels: map[string]string{endpoint.OwnerLabelKey: "me"}},
+		{DNSName: "b.example.com", RecordType: endpoint.RecordTypeA, Labels: map[string]string{endpoint.OwnerLabelKey: "me"}},
+	}
+
+	p := &Plan{
+		Current:        current,
+		Desired:        nil,
+		ManagedRecords: []string{endpoint.RecordTypeA},
+		TXTOwner:       "me",
+		SafetyLimits:   SafetyLimits{MaxDeletePercent: 10},
+	}
+
+	result := p.Calculate()
+
+	require.NotNil(t, result.Changes)
+	assert.NotEmpty(t, result.Changes.Aborted)
+	assert.Empty(t, result.Changes.Delete)
+	// Changes2 must always be initialized, even when aborted, so that
+	// callers can unconditionally call result.Changes2.HasChanges().
+	require.NotNil(t, result.Changes2)
+	assert.False(t, result.Changes2.HasChanges())
+}
+
+func TestCalculateDoesNotAbortWhenWithinSafetyLimits(t *testing.T) {
+	current := []*endpoint.Endpoint{
+		{DNSName: "a.example.com", RecordType: endpoint.RecordTypeA, Labels: map[string]string{endpoint.OwnerLabelKey: "me"}},
+	}
+
+	p := &Plan{
+		Current:        current,
+		Desired:        nil,
+		ManagedRecords: []string{endpoint.RecordTypeA},
+		TXTOwner:       "me",
+		SafetyLimits:   SafetyLimits{MaxDeletePercent: 100},
+	}
+
+	result := p.Calculate()
+
+	require.NotNil(t, result.Changes)
+	assert.Empty(t, result.Changes.Aborted)
+	assert.Len(t, result.Changes.Delete, 1)
+	require.NotNil(t, result.Changes2)
+	assert.True(t, result.Changes2.HasChanges())
+}
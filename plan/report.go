@@ -0,0 +1,94 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// ReportDecision is the action the planner took (or didn't take) for a given planKey.
+type ReportDecision string
+
+const (
+	ReportDecisionCreate ReportDecision = "create"
+	ReportDecisionUpdate ReportDecision = "update"
+	ReportDecisionDelete ReportDecision = "delete"
+	ReportDecisionNoop   ReportDecision = "no-op"
+)
+
+// ReportEntry records everything Calculate() considered and decided for a
+// single planKey, so a user can audit why a record was or wasn't touched
+// without grepping debug logs.
+type ReportEntry struct {
+	PlanKey planKey
+	// Candidates identifies every desired endpoint considered for this key,
+	// by their source (endpoint.ResourceLabelKey label, when set).
+	Candidates []string
+	// Resolver is the ConflictResolver implementation that fired for this key, if any.
+	Resolver string
+	// Decision is the action taken.
+	Decision ReportDecision
+	// Reason is a short explanation of the decision (e.g. "target changed",
+	// "ttl changed", "owner migration", "ignored by filter", "deletion dropped by policy").
+	Reason string
+	// TargetsAdded/TargetsRemoved mirror Change2's diff for update decisions.
+	TargetsAdded   endpoint.Targets
+	TargetsRemoved endpoint.Targets
+}
+
+// PlanReport is the structured, per-planKey account of a Calculate() pass,
+// suitable for serializing to JSON with encoding/json so planner decisions
+// can be audited and diffed in CI. This package does not write the report to
+// a file itself.
+type PlanReport struct {
+	Entries []*ReportEntry
+}
+
+func (r *PlanReport) add(entry *ReportEntry) {
+	r.Entries = append(r.Entries, entry)
+}
+
+// reportPolicyDroppedDeletes adds one ReportEntry per planKey present in
+// prePolicyDeletes but missing from postPolicyDeletes, so a consumer
+// auditing the JSON report can tell exactly which records a Policy spared,
+// rather than only a single aggregate count.
+func reportPolicyDroppedDeletes(report *PlanReport, prePolicyDeletes, postPolicyDeletes []*endpoint.Endpoint) {
+	remaining := make(map[planKey]bool, len(postPolicyDeletes))
+	for _, ep := range postPolicyDeletes {
+		remaining[planKeyFor(ep)] = true
+	}
+	for _, ep := range prePolicyDeletes {
+		key := planKeyFor(ep)
+		if !remaining[key] {
+			report.add(&ReportEntry{PlanKey: key, Decision: ReportDecisionNoop, Reason: "deletion dropped by policy"})
+		}
+	}
+}
+
+func candidateSources(candidates []*endpoint.Endpoint) []string {
+	sources := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if source, ok := c.Labels[endpoint.ResourceLabelKey]; ok && source != "" {
+			sources = append(sources, source)
+		} else {
+			sources = append(sources, fmt.Sprintf("%s/%s", c.DNSName, c.RecordType))
+		}
+	}
+	return sources
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2017 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plan
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+func TestPlanReportMarshalJSONIncludesPlanKey(t *testing.T) {
+	report := &PlanReport{}
+	report.add(&ReportEntry{
+		PlanKey:  planKey{dnsName: "foo.example.com.", setIdentifier: "blue", recordType: endpoint.RecordTypeA},
+		Decision: ReportDecisionDelete,
+		Reason:   "no desired candidates remain for this key",
+	})
+
+	data, err := json.Marshal(report)
+	require.NoError(t, err)
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	entries := decoded["Entries"].([]interface{})
+	require.Len(t, entries, 1)
+	planKeyJSON := entries[0].(map[string]interface{})["PlanKey"].(map[string]interface{})
+
+	assert.Equal(t, "foo.example.com.", planKeyJSON["dnsName"])
+	assert.Equal(t, "blue", planKeyJSON["setIdentifier"])
+	assert.Equal(t, endpoint.RecordTypeA, planKeyJSON["recordType"])
+}
+
+func TestPlanKeyJSONRoundTrip(t *testing.T) {
+	original := planKey{dnsName: "foo.example.com.", setIdentifier: "blue", recordType: endpoint.RecordTypeA}
+
+	data, err := json.Marshal(original)
+	require.NoError(t, err)
+
+	var decoded planKey
+	require.NoError(t, json.Unmarshal(data, &decoded))
+
+	assert.Equal(t, original, decoded)
+}
+
+func TestReportPolicyDroppedDeletesEmitsOneEntryPerDroppedKey(t *testing.T) {
+	kept := &endpoint.Endpoint{DNSName: "kept.example.com", RecordType: endpoint.RecordTypeA}
+	dropped := &endpoint.Endpoint{DNSName: "dropped.example.com", RecordType: endpoint.RecordTypeA}
+
+	report := &PlanReport{}
+	reportPolicyDroppedDeletes(report, []*endpoint.Endpoint{kept, dropped}, []*endpoint.Endpoint{kept})
+
+	require.Len(t, report.Entries, 1)
+	entry := report.Entries[0]
+	assert.Equal(t, planKeyFor(dropped), entry.PlanKey)
+	assert.Equal(t, ReportDecisionNoop, entry.Decision)
+	assert.Equal(t, "deletion dropped by policy", entry.Reason)
+}
+
+func TestReportPolicyDroppedDeletesNoneDropped(t *testing.T) {
+	kept := &endpoint.Endpoint{DNSName: "kept.example.com", RecordType: endpoint.RecordTypeA}
+
+	report := &PlanReport{}
+	reportPolicyDroppedDeletes(report, []*endpoint.Endpoint{kept}, []*endpoint.Endpoint{kept})
+
+	assert.Empty(t, report.Entries)
+}
+
+func TestCandidateSourcesFallsBackToDNSNameAndType(t *testing.T) {
+	withSource := &endpoint.Endpoint{DNSName: "foo.example.com", RecordType: endpoint.RecordTypeA,
+		Labels: map[string]string{endpoint.ResourceLabelKey: "ingress/default/foo"}}
+	withoutSource := &endpoint.Endpoint{DNSName: "bar.example.com", RecordType: endpoint.RecordTypeCNAME}
+
+	sources := candidateSources([]*endpoint.Endpoint{withSource, withoutSource})
+
+	assert.Equal(t, []string{"ingress/default/foo", "bar.example.com/CNAME"}, sources)
+}